@@ -0,0 +1,135 @@
+package sleepy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogFormat selects how requests are logged.
+type AccessLogFormat int
+
+const (
+	// DefaultLogFormat is the sleepy specific format
+	DefaultLogFormat AccessLogFormat = iota
+	// CommonLogFormat is the Apache/NCSA Common Log Format
+	CommonLogFormat
+	// CombinedLogFormat is the Apache Combined Log Format
+	// (Common Log Format plus referer and user agent)
+	CombinedLogFormat
+)
+
+// WithAccessLogFormat logs one line per request in the given format.
+// Common and Combined lines are written to the Logger without any
+// prefix (create it with log.New(w, "", 0) to get plain lines) and
+// replace the sleepy specific request logging. They are written by the
+// handler returned by Handler, so requests answered by the router
+// itself (404, 405, OPTIONS, redirects) are logged as well. Error diagnostics like
+// recovered panics are still logged in the sleepy format.
+func WithAccessLogFormat(format AccessLogFormat) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.accessLogFormat = format
+	}
+}
+
+// Handler returns the http.Handler to serve the API with: its mux,
+// wrapped with access logging if a standard access log format is set.
+// Start uses it, custom servers should use it instead of Mux.
+func Handler(api API) http.Handler {
+	mux := api.Mux()
+
+	defaultAPI, ok := api.(*DefaultAPI)
+	if !ok || defaultAPI.accessLogFormat == DefaultLogFormat {
+		return mux
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		rec := &responseRecorder{ResponseWriter: rw}
+		// the router may rewrite the URL (e.g. for redirects)
+		defer defaultAPI.logAccess(request, defaultAPI.logURI(request), rec, time.Now())
+		mux.ServeHTTP(rec, request)
+	})
+}
+
+// responseRecorder tracks status and body size for access logs
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (api *DefaultAPI) logAccess(r *http.Request, uri string, rec *responseRecorder, start time.Time) {
+	if api.Logger == nil {
+		return
+	}
+
+	host := r.Header.Get("X-Real-IP")
+	if host == "" {
+		host = r.RemoteAddr
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+
+	status := rec.status
+	if status == 0 {
+		if r.Context().Err() != nil {
+			status = StatusClientClosedRequest
+		} else {
+			status = http.StatusOK
+		}
+	}
+
+	size := "-"
+	if rec.size > 0 {
+		size = strconv.Itoa(rec.size)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		logQuote(r.Method+" "+uri+" "+r.Proto), status, size)
+
+	if api.accessLogFormat == CombinedLogFormat {
+		line += fmt.Sprintf(" %s %s", logQuote(r.Referer()), logQuote(r.UserAgent()))
+	}
+
+	api.Logger.Println(line)
+}
+
+// logQuote quotes s for the log line, empty values become "-"
+func logQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
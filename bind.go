@@ -0,0 +1,177 @@
+package sleepy
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// BindError describes a value which couldn't be bound by Bind.
+// It's caused by the client, so it's suitable for a 400 response,
+// except for bodies of unknown type: errors.Is(err, ErrUnsupportedMediaType)
+// reports those, which should be answered with 415.
+type BindError struct {
+	// Source is "path", "query" or "body"
+	Source string
+	// Name is the parameter name, empty for body errors
+	Name string
+	// Value is the raw value which failed to convert
+	Value string
+	// Type is the name of the target type
+	Type string
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	if e.Source == "body" {
+		return fmt.Sprintf("invalid request body: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid %s parameter %q: %q is not a valid %s", e.Source, e.Name, e.Value, e.Type)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Bind populates the struct pointed to by v from the request. For POST,
+// PUT and PATCH a non-empty body is decoded first with Decode (form
+// bodies are skipped, they are already parsed into r.Form), then fields tagged with
+// `path:"name"` are set from params and fields tagged with
+// `query:"name"` from the query string. Missing values leave fields
+// untouched. Conversion failures are returned as *BindError, bodies
+// over the API's size limit as *http.MaxBytesError (answer with 413).
+func Bind(r *http.Request, params httprouter.Params, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("Bind requires a non-nil pointer to a struct")
+	}
+
+	switch r.Method {
+	case POST, PUT, PATCH:
+		if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 && !isFormBody(r) {
+			if err := Decode(r, v); err != nil && err != io.EOF {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					return maxErr
+				}
+				return &BindError{Source: "body", Err: err}
+			}
+		}
+	}
+
+	return bindFields(rv.Elem(), r, params)
+}
+
+func isFormBody(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+func bindFields(rv reflect.Value, r *http.Request, params httprouter.Params) error {
+	rt := rv.Type()
+	query := r.URL.Query()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		fv := rv.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindFields(fv, r, params); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !fv.CanSet() {
+			continue
+		}
+
+		if name := field.Tag.Get("path"); name != "" {
+			if value := params.ByName(name); value != "" {
+				if err := setValue(fv, value); err != nil {
+					return &BindError{Source: "path", Name: name, Value: value, Type: field.Type.String(), Err: err}
+				}
+			}
+		}
+
+		if name := field.Tag.Get("query"); name != "" {
+			values, ok := query[name]
+			if !ok || len(values) == 0 {
+				continue
+			}
+			if fv.Kind() == reflect.Slice && !reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) {
+				slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+				for j, value := range values {
+					if err := setValue(slice.Index(j), value); err != nil {
+						return &BindError{Source: "query", Name: name, Value: value, Type: fv.Type().Elem().String(), Err: err}
+					}
+				}
+				fv.Set(slice)
+				continue
+			}
+			if err := setValue(fv, values[0]); err != nil {
+				return &BindError{Source: "query", Name: name, Value: values[0], Type: field.Type.String(), Err: err}
+			}
+		}
+	}
+
+	return nil
+}
+
+// setValue converts s to the type of fv and stores it
+func setValue(fv reflect.Value, s string) error {
+	if fv.Kind() == reflect.Ptr {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValue(elem.Elem(), s); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+
+	return nil
+}
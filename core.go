@@ -1,6 +1,8 @@
 package sleepy
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,8 +10,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -32,6 +39,10 @@ const (
 	PATCH = "PATCH"
 )
 
+// StatusClientClosedRequest is logged (nginx style) for requests
+// aborted because the client went away before the response was written.
+const StatusClientClosedRequest = 499
+
 var (
 	httpReadTimeout  uint
 	httpWriteTimeout uint
@@ -78,6 +89,15 @@ type PatchSupported interface {
 	Patch(*http.Request, http.Header, httprouter.Params) (int, interface{}, http.Header)
 }
 
+// HeaderError is an error which carries response headers, e.g. a 401
+// with WWW-Authenticate. When a resource returns such an error as data
+// its headers are merged into the response; headers returned by the
+// resource itself take precedence.
+type HeaderError interface {
+	error
+	Headers() http.Header
+}
+
 // API is the interface to manage a group of resources by routing requests
 // to the correct method on a matching resource and marshalling
 // the returned data to JSON for the HTTP response.
@@ -93,6 +113,9 @@ type API interface {
 	// the generated handler function with a give wrapper function to allow
 	// to hook in Gzip support and similar.
 	AddResourceWithWrapper(resource interface{}, wrapper func(handler httprouter.Handle) httprouter.Handle, paths ...string)
+	// AddVersionEndpoint registers a GET resource on path returning
+	// the given build information as JSON.
+	AddVersionEndpoint(path string, info VersionInfo)
 	// Start causes the API to begin serving requests on the given port.
 	Start(host string, port int) error
 	// SetMux sets the Mux to use by an API.
@@ -112,8 +135,24 @@ type DefaultAPI struct {
 
 	mux            *httprouter.Router
 	muxInitialized bool
+
+	maxBodySize          int64
+	hideMethodNotAllowed bool
+	gzipEnabled          bool
+	gzipMinSize          int
+	requestIDHeader      string
+	requestIDGenerator   func() string
+	recoverPanics        bool
+	panicMapper          PanicMapper
+	redactedParams       map[string]bool
+	accessLogFormat      AccessLogFormat
 }
 
+// PanicMapper converts a recovered panic value into a status code and
+// response data. It returns false for panics it doesn't know, those
+// are answered with 500.
+type PanicMapper func(recovered interface{}) (int, interface{}, bool)
+
 // NewAPI allocates and returns a new API.
 func NewAPI(options ...func(*DefaultAPI)) API {
 
@@ -127,15 +166,99 @@ func NewAPI(options ...func(*DefaultAPI)) API {
 	return &api
 }
 
+// DefaultMaxBodySize is the request body limit used unless
+// another one is set with WithMaxBodySize.
+const DefaultMaxBodySize = 10 << 20
+
+// WithMaxBodySize limits request bodies to n bytes, larger requests
+// are rejected with 413 before the form is parsed. Zero selects
+// DefaultMaxBodySize, a negative value disables the limit.
+func WithMaxBodySize(n int64) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.maxBodySize = n
+	}
+}
+
+// WithHiddenMethodNotAllowed makes the API answer unsupported methods
+// with 404 instead of 405, so clients can't probe which paths exist.
+// Automatic OPTIONS replies are disabled as well because their Allow
+// header would reveal the same information. Note that this breaks REST
+// semantics and should only be used when hiding routes matters.
+func WithHiddenMethodNotAllowed() func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.hideMethodNotAllowed = true
+	}
+}
+
+// WithGzip enables gzip compression of buffered responses of at least
+// minSize bytes for clients accepting it. The compressed body is only
+// sent when it is actually smaller than the original one.
+func WithGzip(minSize int) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.gzipEnabled = true
+		api.gzipMinSize = minSize
+	}
+}
+
+// WithRecovery recovers panics raised by resources. Panics recognized
+// by mapper are turned into regular responses, all others are logged
+// with a stack trace and answered with 500. The mapper may be nil.
+func WithRecovery(mapper PanicMapper) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.recoverPanics = true
+		api.panicMapper = mapper
+	}
+}
+
+// WithRedactedQueryParams replaces values of the named query parameters
+// (matched case-insensitively) in logs, e.g. "token" or "password".
+func WithRedactedQueryParams(names ...string) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		if api.redactedParams == nil {
+			api.redactedParams = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			api.redactedParams[strings.ToLower(name)] = true
+		}
+	}
+}
+
 // SetLogger sets log.Logger for loging all requests
 func (api *DefaultAPI) SetLogger(logger *log.Logger) {
 	api.Logger = logger
 }
 
+// bodyLimit returns the request body limit, zero or less means none
+func (api *DefaultAPI) bodyLimit() int64 {
+	if api.maxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return api.maxBodySize
+}
+
 func (api *DefaultAPI) requestHandler(resource interface{}) httprouter.Handle {
 	return func(rw http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
-		if request.ParseForm() != nil {
+		if api.requestIDHeader != "" {
+			request = api.withRequestID(rw, request)
+		}
+
+		if maxBodySize := api.bodyLimit(); maxBodySize > 0 {
+			if request.ContentLength > maxBodySize {
+				api.logRequest(request, http.StatusRequestEntityTooLarge, "Content-Length %d exceeds limit", request.ContentLength)
+				rw.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			request.Body = http.MaxBytesReader(rw, request.Body, maxBodySize)
+		}
+
+		if err := request.ParseForm(); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				api.logRequest(request, http.StatusRequestEntityTooLarge, "request body exceeds limit")
+				rw.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
 			api.logRequest(request, http.StatusBadRequest, "request.ParseForm was nil")
 			rw.WriteHeader(http.StatusBadRequest)
 			return
@@ -171,32 +294,67 @@ func (api *DefaultAPI) requestHandler(resource interface{}) httprouter.Handle {
 		}
 
 		if handler == nil {
-			api.logRequest(request, http.StatusMethodNotAllowed, "Handler was nil")
-			rw.WriteHeader(http.StatusMethodNotAllowed)
+			code := http.StatusMethodNotAllowed
+			if api.hideMethodNotAllowed {
+				code = http.StatusNotFound
+			}
+			api.logRequest(request, code, "Handler was nil")
+			rw.WriteHeader(code)
 			return
 		}
 
-		code, data, header := handler(request, request.Header, params)
+		code, data, header, ok := api.callHandler(handler, request, params)
+		if !ok {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		api.logRequest(request, code, "OK")
 
+		if herr, ok := data.(HeaderError); ok {
+			header = mergeHeaders(header, herr.Headers())
+		}
+
 		var content []byte
 		var err error
 
+		// checked first, neither redirects nor raw -200 apply to multipart
+		if m, ok := data.(*MultipartResponse); ok {
+			if code < 200 || code > 999 {
+				m.close()
+				api.logRequestError(request, http.StatusInternalServerError, "invalid status %d for multipart response", code)
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			api.writeMultipart(rw, request, code, header, m)
+			return
+		}
+
 		if http.StatusFound == code || http.StatusMovedPermanently == code || http.StatusTemporaryRedirect == code {
 			http.Redirect(rw, request, data.(string), code)
 			return
 		}
 
-		if -200 != code {
+		marshalled := -200 != code
+
+		if marshalled {
+			// don't waste time marshalling for a client which is already gone
+			if err = request.Context().Err(); err != nil {
+				api.logRequest(request, StatusClientClosedRequest, "client gone before marshalling: %s", err)
+				return
+			}
 			content, err = json.MarshalIndent(data, "", "  ")
 			// content, err = json.Marshal(data)
+			if err == nil && request.Context().Err() != nil {
+				api.logRequest(request, StatusClientClosedRequest, "client gone during marshalling: %s", request.Context().Err())
+				return
+			}
 		} else {
 			code = 200
 			content = data.([]byte)
 		}
 
 		if err != nil {
-			api.logRequest(request, http.StatusInternalServerError, "err in json.MarshalIndent: %s", err)
+			api.logRequestError(request, http.StatusInternalServerError, "err in json.MarshalIndent: %s", err)
 			rw.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -206,11 +364,128 @@ func (api *DefaultAPI) requestHandler(resource interface{}) httprouter.Handle {
 				rw.Header().Add(name, value)
 			}
 		}
+
+		// resources may still override the type of marshalled data
+		if marshalled && rw.Header().Get("Content-Type") == "" {
+			rw.Header().Set("Content-Type", "application/json")
+		}
+
+		// Content-Length is only known for sure when we own the encoding,
+		// a wrapper (see AddResourceWithWrapper) may still change the body
+		if api.gzipEnabled {
+			var owned bool
+			if content, owned = api.compress(rw, request, content); owned {
+				rw.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			}
+		}
+
 		rw.WriteHeader(code)
 		rw.Write(content)
 	}
 }
 
+// mergeHeaders returns header extended with values from extra
+// for names not present in header
+func mergeHeaders(header, extra http.Header) http.Header {
+	merged := header.Clone()
+	if merged == nil {
+		merged = http.Header{}
+	}
+	for name, values := range extra {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := merged[name]; ok {
+			continue
+		}
+		merged[name] = append([]string(nil), values...)
+	}
+	return merged
+}
+
+// callHandler runs the resource handler, recovering panics if enabled;
+// ok is false for unexpected panics which should be answered with 500
+func (api *DefaultAPI) callHandler(handler func(*http.Request, http.Header, httprouter.Params) (int, interface{}, http.Header),
+	request *http.Request, params httprouter.Params) (code int, data interface{}, header http.Header, ok bool) {
+
+	if api.recoverPanics {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			if api.panicMapper != nil {
+				if code, data, ok = api.panicMapper(recovered); ok {
+					header = nil
+					return
+				}
+			}
+			api.logRequestError(request, http.StatusInternalServerError, "panic: %v\n%s", recovered, debug.Stack())
+			code, data, header, ok = http.StatusInternalServerError, nil, nil, false
+		}()
+	}
+
+	code, data, header = handler(request, request.Header, params)
+	return code, data, header, true
+}
+
+// compress returns the gzipped content and sets Content-Encoding
+// if that is acceptable for the client and makes the body smaller,
+// otherwise the original content is returned. owned is false when
+// Content-Encoding was already set by someone else (e.g. a wrapper),
+// so the bytes sent may differ from the returned content.
+func (api *DefaultAPI) compress(rw http.ResponseWriter, request *http.Request, content []byte) (result []byte, owned bool) {
+	if rw.Header().Get("Content-Encoding") != "" {
+		return content, false
+	}
+
+	rw.Header().Add("Vary", "Accept-Encoding")
+
+	if len(content) < api.gzipMinSize || !acceptsGzip(request) {
+		return content, true
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		api.logRequestError(request, http.StatusOK, "err in gzip.Write: %s", err)
+		return content, true
+	}
+	if err := zw.Close(); err != nil {
+		api.logRequestError(request, http.StatusOK, "err in gzip.Close: %s", err)
+		return content, true
+	}
+
+	if buf.Len() >= len(content) {
+		return content, true
+	}
+
+	rw.Header().Set("Content-Encoding", "gzip")
+	return buf.Bytes(), true
+}
+
+// acceptsGzip checks Accept-Encoding for gzip (or *) with non-zero quality
+func acceptsGzip(request *http.Request) bool {
+	for _, header := range request.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			q := strings.ReplaceAll(strings.ToLower(params), " ", "")
+			if strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
 // Mux returns the Mux used by an API. If a Mux has
 // does not yet exist, a new one will be created and returned.
 func (api *DefaultAPI) Mux() *httprouter.Router {
@@ -220,6 +495,7 @@ func (api *DefaultAPI) Mux() *httprouter.Router {
 
 	api.mux = httprouter.New()
 	api.muxInitialized = true
+	api.configureMux()
 
 	// TODO log 404
 	//
@@ -234,9 +510,17 @@ func (api *DefaultAPI) SetMux(mux *httprouter.Router) error {
 	}
 	api.mux = mux
 	api.muxInitialized = true
+	api.configureMux()
 	return nil
 }
 
+func (api *DefaultAPI) configureMux() {
+	if api.hideMethodNotAllowed {
+		api.mux.HandleMethodNotAllowed = false
+		api.mux.HandleOPTIONS = false
+	}
+}
+
 // AddResource adds a new resource to an API. The API will route
 // requests that match one of the given paths to the matching HTTP
 // method on the resource.
@@ -311,14 +595,27 @@ func (api *DefaultAPI) Start(host string, port int) error {
 		api.log("Listening on http://[any]%s", listenString)
 	}
 
-	server := &http.Server{
-		Addr:           listenString,
-		Handler:        api.Mux(),
+	server := NewServer(listenString, Handler(api))
+	ShutdownOnSignal(server.Shutdown)
+
+	return server.Serve(listener)
+}
+
+// NewServer returns an http.Server for handler with the timeouts
+// and limits used by Start.
+func NewServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           addr,
+		Handler:        handler,
 		ReadTimeout:    20 * time.Second,
 		WriteTimeout:   20 * time.Second,
 		MaxHeaderBytes: 1 << 15,
 	}
+}
 
+// ShutdownOnSignal gracefully shuts down servers on SIGINT or SIGTERM,
+// all shutdown functions run in parallel with a common timeout.
+func ShutdownOnSignal(shutdown ...func(context.Context) error) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT)
 	signal.Notify(c, syscall.SIGTERM)
@@ -332,7 +629,15 @@ func (api *DefaultAPI) Start(host string, port int) error {
 		defer cancel()
 
 		// start http shutdown
-		server.Shutdown(ctx)
+		var wg sync.WaitGroup
+		for _, fn := range shutdown {
+			wg.Add(1)
+			go func(fn func(context.Context) error) {
+				defer wg.Done()
+				fn(ctx)
+			}(fn)
+		}
+		wg.Wait()
 
 		// verify, in worst case call cancel via defer
 		select {
@@ -342,8 +647,6 @@ func (api *DefaultAPI) Start(host string, port int) error {
 		case <-ctx.Done():
 		}
 	}()
-
-	return server.Serve(listener)
 }
 
 func (api *DefaultAPI) log(msg string, args ...interface{}) {
@@ -361,6 +664,18 @@ func (api *DefaultAPI) log(msg string, args ...interface{}) {
 
 func (api *DefaultAPI) logRequest(r *http.Request, code int, msg string, args ...interface{}) {
 
+	// requests are logged by logAccess in standard formats
+	if api.accessLogFormat != DefaultLogFormat {
+		return
+	}
+
+	api.logRequestError(r, code, msg, args...)
+}
+
+// logRequestError logs diagnostics for the request,
+// unlike logRequest regardless of the access log format
+func (api *DefaultAPI) logRequestError(r *http.Request, code int, msg string, args ...interface{}) {
+
 	m := msg
 	if len(args) > 0 {
 		m = fmt.Sprintf(msg, args...)
@@ -371,5 +686,38 @@ func (api *DefaultAPI) logRequest(r *http.Request, code int, msg string, args ..
 		remote = r.RemoteAddr
 	}
 
-	api.log("[%v] %s %s/%s %d, %s", remote, r.Method, r.URL.Path, r.URL.RawQuery, code, m)
+	if id := RequestID(r); id != "" {
+		remote = fmt.Sprintf("%s %s", remote, id)
+	}
+
+	api.log("[%v] %s %s %d, %s", remote, r.Method, api.logURI(r), code, m)
+}
+
+// logURI returns path and query of the request with values
+// of sensitive query parameters redacted
+func (api *DefaultAPI) logURI(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	if len(api.redactedParams) == 0 {
+		return r.URL.Path + "?" + r.URL.RawQuery
+	}
+
+	// go through the raw pairs to keep the original order and encoding
+	pairs := strings.Split(r.URL.RawQuery, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		if !hasValue {
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if api.redactedParams[strings.ToLower(name)] {
+			pairs[i] = key + "=REDACTED"
+		}
+	}
+
+	return r.URL.Path + "?" + strings.Join(pairs, "&")
 }
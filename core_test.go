@@ -1,17 +1,29 @@
 package sleepy
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"net/url"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 type Item struct{}
 
-func (item Item) Get(values url.Values, headers http.Header) (int, interface{}, http.Header) {
+func (item Item) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
 	items := []string{"item1", "item2"}
 	data := map[string][]string{"items": items}
 	return 200, data, nil
@@ -40,3 +52,657 @@ func TestBasicGet(t *testing.T) {
 		t.Error("Not equal.")
 	}
 }
+
+type Form struct{}
+
+func (form Form) Post(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return 200, r.PostForm, nil
+}
+
+func TestMaxBodySize(t *testing.T) {
+
+	var api = NewAPI(WithMaxBodySize(16))
+	api.AddResource(Form{}, "/form")
+
+	body := "q=" + strings.Repeat("x", 32)
+
+	// announced length is checked up front
+	req := httptest.NewRequest(POST, "/form", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rec.Code)
+	}
+
+	// unknown length is caught while parsing the form
+	req = httptest.NewRequest(POST, "/form", ioutil.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(POST, "/form", strings.NewReader("q=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestDefaultMaxBodySize(t *testing.T) {
+
+	for _, c := range []struct {
+		options []func(*DefaultAPI)
+		code    int
+	}{
+		{nil, http.StatusRequestEntityTooLarge},
+		{[]func(*DefaultAPI){WithMaxBodySize(-1)}, http.StatusOK},
+	} {
+		var api = NewAPI(c.options...)
+		api.AddResource(Form{}, "/form")
+
+		req := httptest.NewRequest(POST, "/form", strings.NewReader("q=x"))
+		req.ContentLength = DefaultMaxBodySize + 1
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("expected %d, got %d", c.code, rec.Code)
+		}
+	}
+}
+
+type Bound struct{}
+
+func (b Bound) Post(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	var v bindTarget
+	if err := Bind(r, params, &v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return http.StatusRequestEntityTooLarge, err.Error(), nil
+		}
+		return http.StatusBadRequest, err.Error(), nil
+	}
+	return 200, v, nil
+}
+
+func TestBindMaxBodySize(t *testing.T) {
+
+	var api = NewAPI(WithMaxBodySize(16))
+	api.AddResource(Bound{}, "/bound")
+
+	req := httptest.NewRequest(POST, "/bound", ioutil.NopCloser(strings.NewReader(`{"name": "`+strings.Repeat("x", 32)+`"}`)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rec.Code)
+	}
+}
+
+func TestHiddenMethodNotAllowed(t *testing.T) {
+
+	for _, c := range []struct {
+		options     []func(*DefaultAPI)
+		setMux      bool
+		code        int
+		allowHeader bool
+	}{
+		{nil, false, http.StatusMethodNotAllowed, true},
+		{[]func(*DefaultAPI){WithHiddenMethodNotAllowed()}, false, http.StatusNotFound, false},
+		{[]func(*DefaultAPI){WithHiddenMethodNotAllowed()}, true, http.StatusNotFound, false},
+	} {
+		var api = NewAPI(c.options...)
+		if c.setMux {
+			if err := api.SetMux(httprouter.New()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		api.AddResource(Item{}, "/items")
+
+		rec := httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, httptest.NewRequest(DELETE, "/items", nil))
+		if rec.Code != c.code {
+			t.Errorf("DELETE: expected %d, got %d", c.code, rec.Code)
+		}
+
+		// automatic OPTIONS replies would leak routes via Allow
+		rec = httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/items", nil))
+		if c.allowHeader {
+			if rec.Header().Get("Allow") == "" {
+				t.Error("OPTIONS: expected Allow header")
+			}
+		} else {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("OPTIONS: expected 404, got %d", rec.Code)
+			}
+			if allow := rec.Header().Get("Allow"); allow != "" {
+				t.Errorf("OPTIONS: unexpected Allow header %q", allow)
+			}
+		}
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddVersionEndpoint("/version", VersionInfo{Version: "1.2.3", Commit: "abc123", BuildTime: "2020-01-01T00:00:00Z"})
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if rec.Body.String() != "{\n  \"version\": \"1.2.3\",\n  \"commit\": \"abc123\",\n  \"buildTime\": \"2020-01-01T00:00:00Z\"\n}" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+}
+
+type Typed struct{}
+
+func (ty Typed) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	if r.URL.Query().Get("raw") != "" {
+		return -200, []byte("raw"), nil
+	}
+	return 200, "typed", http.Header{"Content-type": {"application/vnd.sleepy+json"}}
+}
+
+func TestContentType(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddResource(Item{}, "/items")
+	api.AddResource(Typed{}, "/typed")
+
+	for path, ct := range map[string]string{
+		"/items":       "application/json",
+		"/typed":       "application/vnd.sleepy+json",
+		"/typed?raw=1": "",
+	} {
+		rec := httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, path, nil))
+		if got := rec.Result().Header.Get("Content-Type"); got != ct {
+			t.Errorf("%s: expected Content-Type %q, got %q", path, ct, got)
+		}
+	}
+}
+
+type staticFlags map[string]bool
+
+func (f staticFlags) EvaluateFlags(ctx context.Context) (map[string]bool, error) {
+	return f, nil
+}
+
+type Flagged struct{}
+
+func (f Flagged) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	if err := FlagError(r); err != nil {
+		return http.StatusServiceUnavailable, err.Error(), nil
+	}
+	return 200, map[string]bool{"beta": Flag(r, "beta"), "other": Flag(r, "other")}, nil
+}
+
+type failingFlags struct{}
+
+func (f failingFlags) EvaluateFlags(ctx context.Context) (map[string]bool, error) {
+	return map[string]bool{"beta": true}, errors.New("flag backend down")
+}
+
+func TestFlagWrapper(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddResourceWithWrapper(Flagged{}, FlagWrapper(staticFlags{"beta": true}), "/flags")
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/flags", nil))
+	if rec.Body.String() != "{\n  \"beta\": true,\n  \"other\": false\n}" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+
+	api.AddResourceWithWrapper(Flagged{}, FlagWrapper(failingFlags{}), "/failing")
+
+	rec = httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/failing", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != `"flag backend down"` {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+type Big struct{}
+
+func (b Big) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return 200, strings.Repeat("sleepy ", 200), nil
+}
+
+func TestGzip(t *testing.T) {
+
+	var api = NewAPI(WithGzip(64))
+	api.AddResource(Item{}, "/items")
+	api.AddResource(Big{}, "/big")
+
+	for _, c := range []struct {
+		path     string
+		encoding string
+		gzipped  bool
+	}{
+		{"/big", "gzip, deflate", true},
+		{"/big", "gzip;q=0", false},
+		{"/big", "", false},
+		{"/items", "gzip", false},
+	} {
+		req := httptest.NewRequest(GET, c.path, nil)
+		if c.encoding != "" {
+			req.Header.Set("Accept-Encoding", c.encoding)
+		}
+		rec := httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, req)
+
+		if gzipped := rec.Header().Get("Content-Encoding") == "gzip"; gzipped != c.gzipped {
+			t.Errorf("%s with %q: expected gzipped=%v", c.path, c.encoding, c.gzipped)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("%s: Content-Length %s doesn't match body length %d", c.path, cl, rec.Body.Len())
+		}
+	}
+}
+
+type Echo struct{}
+
+func (e Echo) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return 200, RequestID(r), nil
+}
+
+func TestRequestID(t *testing.T) {
+
+	var api = NewAPI(WithRequestIDGenerator(func() string { return "generated" }), WithRequestIDHeader("X-Correlation-ID"))
+	api.AddResource(Echo{}, "/id")
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/id", nil))
+	if id := rec.Header().Get("X-Correlation-ID"); id != "generated" || rec.Body.String() != `"generated"` {
+		t.Errorf("unexpected request id %q, body %q", id, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(GET, "/id", nil)
+	req.Header.Set("X-Correlation-ID", "incoming")
+	rec = httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, req)
+	if id := rec.Header().Get("X-Correlation-ID"); id != "incoming" || rec.Body.String() != `"incoming"` {
+		t.Errorf("unexpected request id %q, body %q", id, rec.Body.String())
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddResource(Item{}, "/items")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/items", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected no body for canceled request, got %q", rec.Body.String())
+	}
+}
+
+type bindTarget struct {
+	ID    int      `path:"id"`
+	Limit uint     `query:"limit"`
+	Tags  []string `query:"tag"`
+	Name  string   `json:"name"`
+}
+
+func TestBind(t *testing.T) {
+
+	params := httprouter.Params{{Key: "id", Value: "42"}}
+
+	req := httptest.NewRequest(PUT, "/items/42?limit=10&tag=a&tag=b", strings.NewReader(`{"name": "foo"}`))
+	var v bindTarget
+	if err := Bind(req, params, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != 42 || v.Limit != 10 || len(v.Tags) != 2 || v.Tags[1] != "b" || v.Name != "foo" {
+		t.Errorf("unexpected result %+v", v)
+	}
+
+	req = httptest.NewRequest(GET, "/items/42?limit=abc", nil)
+	err := Bind(req, params, &v)
+	if bindErr, ok := err.(*BindError); !ok || bindErr.Source != "query" || bindErr.Name != "limit" {
+		t.Errorf("expected query BindError, got %v", err)
+	}
+
+	// an empty body needs no decoder
+	req = httptest.NewRequest(POST, "/items/42", nil)
+	req.Header.Set("Content-Type", "application/x-unknown")
+	if err := Bind(req, params, &v); err != nil {
+		t.Errorf("unexpected error for empty body: %v", err)
+	}
+
+	req = httptest.NewRequest(POST, "/items/42", strings.NewReader("foo"))
+	req.Header.Set("Content-Type", "application/x-unknown")
+	if err := Bind(req, params, &v); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("expected ErrUnsupportedMediaType, got %v", err)
+	}
+}
+
+type NotFoundPanic struct{}
+
+type Panicky struct{}
+
+func (p Panicky) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	if r.URL.Query().Get("known") != "" {
+		panic(NotFoundPanic{})
+	}
+	panic("unexpected")
+}
+
+func TestRecovery(t *testing.T) {
+
+	var api = NewAPI(WithRecovery(func(recovered interface{}) (int, interface{}, bool) {
+		if _, ok := recovered.(NotFoundPanic); ok {
+			return http.StatusNotFound, map[string]string{"error": "not found"}, true
+		}
+		return 0, nil, false
+	}))
+	api.AddResource(Panicky{}, "/panic")
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/panic?known=1", nil))
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "{\n  \"error\": \"not found\"\n}" {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+}
+
+type Files struct{}
+
+func (f Files) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	code := 200
+	if c := r.URL.Query().Get("code"); c != "" {
+		code, _ = strconv.Atoi(c)
+	}
+	return code, Multipart(
+		Part{Header: textproto.MIMEHeader{"Content-Type": {"text/plain"}}, Body: strings.NewReader("first")},
+		Part{Header: textproto.MIMEHeader{"Content-Type": {"text/plain"}}, Body: strings.NewReader("second")},
+	), nil
+}
+
+func TestMultipart(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddResource(Files{}, "/files")
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/files", nil))
+
+	mediaType, mparams, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected Content-Type %q", rec.Header().Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(rec.Body, mparams["boundary"])
+	var bodies []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(part)
+		bodies = append(bodies, string(b))
+	}
+	if len(bodies) != 2 || bodies[0] != "first" || bodies[1] != "second" {
+		t.Errorf("unexpected parts %q", bodies)
+	}
+
+	// redirect and raw codes don't apply, invalid ones are rejected
+	for code, expected := range map[string]int{"302": http.StatusFound, "-200": http.StatusInternalServerError, "0": http.StatusInternalServerError} {
+		rec := httptest.NewRecorder()
+		api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/files?code="+code, nil))
+		if rec.Code != expected {
+			t.Errorf("code %s: expected %d, got %d", code, expected, rec.Code)
+		}
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+
+	var buf strings.Builder
+	var api = NewAPI(WithRedactedQueryParams("token", "Password"))
+	api.SetLogger(log.New(&buf, "", 0))
+	api.AddResource(Item{}, "/items")
+
+	api.Mux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(GET, "/items?q=foo&token=secret&PASSWORD=x&flag", nil))
+	api.Mux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(GET, "/items", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], " GET /items?q=foo&token=REDACTED&PASSWORD=REDACTED&flag 200,") {
+		t.Errorf("unexpected log line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], " GET /items 200,") {
+		t.Errorf("unexpected log line %q", lines[1])
+	}
+}
+
+func TestDecode(t *testing.T) {
+
+	type payload struct {
+		Name string `json:"name" xml:"name"`
+	}
+
+	// restore the global registry for other tests
+	decodersMu.RLock()
+	previous, registered := decoders["text/plain"]
+	decodersMu.RUnlock()
+	t.Cleanup(func() {
+		decodersMu.Lock()
+		defer decodersMu.Unlock()
+		if registered {
+			decoders["text/plain"] = previous
+		} else {
+			delete(decoders, "text/plain")
+		}
+	})
+
+	RegisterDecoder("Text/Plain; charset=utf-8", func(r io.Reader, v interface{}) error {
+		b, err := ioutil.ReadAll(r)
+		v.(*payload).Name = string(b)
+		return err
+	})
+
+	for ct, body := range map[string]string{
+		"":                                "{\"name\": \"foo\"}",
+		"application/json; charset=utf-8": "{\"name\": \"foo\"}",
+		"application/xml":                 "<payload><name>foo</name></payload>",
+		"text/plain":                      "foo",
+	} {
+		req := httptest.NewRequest(POST, "/", strings.NewReader(body))
+		if ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+		var v payload
+		if err := Decode(req, &v); err != nil || v.Name != "foo" {
+			t.Errorf("%q: unexpected result %+v, %v", ct, v, err)
+		}
+	}
+
+	req := httptest.NewRequest(POST, "/", strings.NewReader("foo"))
+	req.Header.Set("Content-Type", "application/x-unknown")
+	var v payload
+	if err := Decode(req, &v); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("expected ErrUnsupportedMediaType, got %v", err)
+	}
+}
+
+func TestCombinedLogFormat(t *testing.T) {
+
+	var buf strings.Builder
+	var api = NewAPI(WithAccessLogFormat(CombinedLogFormat), WithRedactedQueryParams("token"))
+	api.SetLogger(log.New(&buf, "", 0))
+	api.AddResource(Item{}, "/items")
+
+	req := httptest.NewRequest(GET, "/items?token=secret", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test \"agent\"")
+	rec := httptest.NewRecorder()
+	Handler(api).ServeHTTP(rec, req)
+
+	line := strings.TrimSpace(buf.String())
+	prefix := "192.0.2.1 - - ["
+	suffix := fmt.Sprintf("] \"GET /items?token=REDACTED HTTP/1.1\" 200 %d \"http://example.com/\" \"test \\\"agent\\\"\"", rec.Body.Len())
+	if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, suffix) {
+		t.Errorf("unexpected log line %q", line)
+	}
+}
+
+type authError struct {
+	Message string `json:"error"`
+}
+
+func (e authError) Error() string {
+	return e.Message
+}
+
+func (e authError) Headers() http.Header {
+	return http.Header{"WWW-Authenticate": {`Bearer realm="sleepy"`}}
+}
+
+type Protected struct{}
+
+func (p Protected) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return http.StatusUnauthorized, authError{Message: "token required"}, nil
+}
+
+func TestHeaderError(t *testing.T) {
+
+	var api = NewAPI()
+	api.AddResource(Protected{}, "/protected")
+
+	rec := httptest.NewRecorder()
+	api.Mux().ServeHTTP(rec, httptest.NewRequest(GET, "/protected", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+	if h := rec.Header().Get("WWW-Authenticate"); h != `Bearer realm="sleepy"` {
+		t.Errorf("unexpected WWW-Authenticate %q", h)
+	}
+	if rec.Body.String() != "{\n  \"error\": \"token required\"\n}" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.zw.Write(b)
+}
+
+func gzipWrapper(handler httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(rw)
+		defer zw.Close()
+		handler(gzipResponseWriter{rw, zw}, r, params)
+	}
+}
+
+func TestGzipWrapper(t *testing.T) {
+
+	// the wrapper compresses on its own, with and without WithGzip
+	for _, options := range [][]func(*DefaultAPI){nil, {WithGzip(10)}} {
+		var api = NewAPI(options...)
+		api.AddResourceWithWrapper(Big{}, gzipWrapper, "/big")
+
+		server := httptest.NewServer(api.Mux())
+
+		resp, err := http.Get(server.URL + "/big")
+		if err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), "sleepy sleepy") {
+			t.Errorf("unexpected body %q", body)
+		}
+	}
+}
+
+func TestCombinedLogFormatPanic(t *testing.T) {
+
+	var buf strings.Builder
+	var api = NewAPI(WithRecovery(nil), WithAccessLogFormat(CombinedLogFormat))
+	api.SetLogger(log.New(&buf, "", 0))
+	api.AddResource(Panicky{}, "/panic")
+
+	Handler(api).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(GET, "/panic", nil))
+
+	out := buf.String()
+	if !strings.Contains(out, "panic: unexpected") || !strings.Contains(out, "goroutine ") {
+		t.Errorf("panic with stack trace missing from log %q", out)
+	}
+	if !strings.Contains(out, "\"GET /panic HTTP/1.1\" 500 -") {
+		t.Errorf("access log line missing from log %q", out)
+	}
+}
+
+func TestAccessLogRouter(t *testing.T) {
+
+	var buf strings.Builder
+	var api = NewAPI(WithAccessLogFormat(CommonLogFormat))
+	api.SetLogger(log.New(&buf, "", 0))
+	api.AddResource(Item{}, "/items")
+
+	// answered by the router, never reaching a resource
+	for _, req := range []*http.Request{
+		httptest.NewRequest(GET, "/unknown", nil),
+		httptest.NewRequest(DELETE, "/items", nil),
+		httptest.NewRequest(GET, "/items/", nil),
+	} {
+		Handler(api).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %q", buf.String())
+	}
+	for i, request := range []string{
+		"\"GET /unknown HTTP/1.1\" 404 ",
+		"\"DELETE /items HTTP/1.1\" 405 ",
+		"\"GET /items/ HTTP/1.1\" 301 ",
+	} {
+		if !strings.Contains(lines[i], request) {
+			t.Errorf("unexpected log line %q", lines[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package sleepy
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+)
+
+// ErrUnsupportedMediaType is returned by Decode when no decoder is
+// registered for the request's Content-Type, usually answered with 415.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]func(io.Reader, interface{}) error{
+		"application/json": decodeJSON,
+		"application/xml":  decodeXML,
+		"text/xml":         decodeXML,
+	}
+)
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func decodeXML(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// RegisterDecoder registers fn for decoding request bodies of the given
+// content type, replacing any previous decoder. Parameters like charset
+// are ignored. JSON and XML decoders are registered by default.
+// It panics if contentType can't be parsed.
+func RegisterDecoder(contentType string, fn func(io.Reader, interface{}) error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		panic(fmt.Sprintf("sleepy: invalid content type %q for decoder: %v", contentType, err))
+	}
+
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[mediaType] = fn
+}
+
+// Decode decodes the request body into v using the decoder registered
+// for the request's Content-Type. Requests without Content-Type are
+// decoded as JSON.
+func Decode(r *http.Request, v interface{}) error {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
+		}
+	}
+
+	decodersMu.RLock()
+	fn, ok := decoders[mediaType]
+	decodersMu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	return fn(r.Body, v)
+}
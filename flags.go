@@ -0,0 +1,54 @@
+package sleepy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// FlagProvider evaluates feature flags for a single request. The context
+// is the request context, so a provider can look up user or tenant
+// values stored there by earlier middleware.
+type FlagProvider interface {
+	EvaluateFlags(ctx context.Context) (map[string]bool, error)
+}
+
+type flagsKey struct{}
+
+// evaluatedFlags is stored in the request context by FlagWrapper
+type evaluatedFlags struct {
+	flags map[string]bool
+	err   error
+}
+
+// FlagWrapper returns a wrapper for AddResourceWithWrapper that evaluates
+// flags with provider once per request and stores the result in the
+// request context. If the provider fails all flags are treated as off
+// and the error is available via FlagError.
+func FlagWrapper(provider FlagProvider) func(handler httprouter.Handle) httprouter.Handle {
+	return func(handler httprouter.Handle) httprouter.Handle {
+		return func(rw http.ResponseWriter, request *http.Request, params httprouter.Params) {
+			flags, err := provider.EvaluateFlags(request.Context())
+			ctx := context.WithValue(request.Context(), flagsKey{}, evaluatedFlags{flags: flags, err: err})
+			handler(rw, request.WithContext(ctx), params)
+		}
+	}
+}
+
+// Flag reports whether the named flag is enabled for the request.
+// Flags are only available for resources added with FlagWrapper.
+func Flag(r *http.Request, name string) bool {
+	evaluated, _ := r.Context().Value(flagsKey{}).(evaluatedFlags)
+	if evaluated.err != nil {
+		return false
+	}
+	return evaluated.flags[name]
+}
+
+// FlagError returns the error of the flag provider for the request,
+// e.g. to log or report a backend outage turning all flags off.
+func FlagError(r *http.Request) error {
+	evaluated, _ := r.Context().Value(flagsKey{}).(evaluatedFlags)
+	return evaluated.err
+}
@@ -1,6 +1,6 @@
 module github.com/kanocz/sleepy
 
-go 1.16
+go 1.19
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
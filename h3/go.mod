@@ -0,0 +1,20 @@
+module github.com/kanocz/sleepy/h3
+
+go 1.24
+
+require (
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/kanocz/sleepy v0.0.0
+	github.com/kavu/go_reuseport v1.5.0
+	github.com/quic-go/quic-go v0.59.0
+)
+
+require (
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+)
+
+replace github.com/kanocz/sleepy => ../
@@ -0,0 +1,109 @@
+// Package h3 serves a sleepy API over HTTP/3 (QUIC) next to TLS.
+// It lives in its own module so that the QUIC dependencies are only
+// pulled in by users who need them.
+package h3
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/kanocz/sleepy"
+	reuseport "github.com/kavu/go_reuseport"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Start causes the API to begin serving requests on the given port
+// over HTTP/3 (QUIC on UDP) and TLS (HTTP/1.1 and HTTP/2 on TCP).
+// TLS responses carry an Alt-Svc header so clients can switch to HTTP/3.
+// Resources must be added to the API before calling Start.
+func Start(api sleepy.API, host string, port int, certFile, keyFile string) error {
+	var logger *log.Logger
+	if defaultAPI, ok := api.(*sleepy.DefaultAPI); ok {
+		logger = defaultAPI.Logger
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		logf(logger, "Error loading TLS certificate: %v", err)
+		return err
+	}
+
+	listenString := fmt.Sprintf("%s:%d", host, port)
+
+	listener, err := reuseport.NewReusablePortListener("tcp4", listenString)
+	if nil != err {
+		logf(logger, "Error reuseport listen: %v", err)
+		return err
+	}
+
+	packetConn, err := reuseport.NewReusablePortPacketConn("udp4", listenString)
+	if nil != err {
+		listener.Close()
+		logf(logger, "Error reuseport UDP listen: %v", err)
+		return err
+	}
+	defer packetConn.Close()
+
+	if host != "" {
+		logf(logger, "Listening on https://%s (HTTP/3 enabled)", listenString)
+	} else {
+		logf(logger, "Listening on https://[any]%s (HTTP/3 enabled)", listenString)
+	}
+
+	h3Server, tlsServer := newServers(api, listenString, port, &tls.Config{Certificates: []tls.Certificate{cert}}, logger)
+	sleepy.ShutdownOnSignal(tlsServer.Shutdown, h3Server.Shutdown)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- tlsServer.ServeTLS(listener, "", "")
+	}()
+	go func() {
+		errs <- h3Server.Serve(packetConn)
+	}()
+
+	// on failure take the other server down too, on shutdown both are
+	// drained by ShutdownOnSignal and must not be closed here
+	err = <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		tlsServer.Close()
+		h3Server.Close()
+	}
+	if other := <-errs; errors.Is(err, http.ErrServerClosed) && other != nil {
+		err = other
+	}
+	return err
+}
+
+// newServers creates the HTTP/3 server and the TLS server announcing it,
+// both serving the API mux
+func newServers(api sleepy.API, addr string, port int, tlsConfig *tls.Config, logger *log.Logger) (*http3.Server, *http.Server) {
+	handler := sleepy.Handler(api)
+
+	h3Server := &http3.Server{
+		Addr:           addr,
+		Port:           port,
+		Handler:        handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+		MaxHeaderBytes: 1 << 15,
+	}
+
+	tlsServer := sleepy.NewServer(addr, http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		if err := h3Server.SetQUICHeaders(rw.Header()); err != nil {
+			logf(logger, "Error setting Alt-Svc: %v", err)
+		}
+		handler.ServeHTTP(rw, request)
+	}))
+	tlsServer.TLSConfig = tlsConfig
+
+	return h3Server, tlsServer
+}
+
+func logf(logger *log.Logger, msg string, args ...interface{}) {
+	if logger == nil {
+		return
+	}
+	logger.Println("sleepy:", fmt.Sprintf(msg, args...))
+}
@@ -0,0 +1,56 @@
+package h3
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kanocz/sleepy"
+)
+
+type Item struct{}
+
+func (item Item) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return 200, []string{"item1", "item2"}, nil
+}
+
+func TestAltSvc(t *testing.T) {
+
+	var api = sleepy.NewAPI()
+	api.AddResource(Item{}, "/items")
+
+	h3Server, tlsServer := newServers(api, "localhost:4443", 4443, &tls.Config{}, nil)
+	if h3Server.Handler != sleepy.Handler(api) {
+		t.Error("HTTP/3 server doesn't serve the API mux")
+	}
+
+	// Alt-Svc is only announced once the QUIC server listens
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go h3Server.Serve(conn)
+	defer h3Server.Close()
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 100; i++ {
+		rec = httptest.NewRecorder()
+		tlsServer.Handler.ServeHTTP(rec, httptest.NewRequest(sleepy.GET, "/items", nil))
+		if rec.Header().Get("Alt-Svc") != "" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if h := rec.Header().Get("Alt-Svc"); h != `h3=":4443"; ma=2592000` {
+		t.Errorf("unexpected Alt-Svc %q", h)
+	}
+}
@@ -0,0 +1,79 @@
+package sleepy
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+)
+
+// Part is a single part of a multipart response. Body is closed after
+// writing if it implements io.Closer.
+type Part struct {
+	Header textproto.MIMEHeader
+	Body   io.Reader
+}
+
+// MultipartResponse can be returned as data from a resource to stream
+// the parts as multipart/mixed instead of marshalling them to JSON.
+type MultipartResponse struct {
+	Parts []Part
+}
+
+// Multipart returns a MultipartResponse streaming the given parts.
+func Multipart(parts ...Part) *MultipartResponse {
+	return &MultipartResponse{Parts: parts}
+}
+
+// close closes all part bodies implementing io.Closer
+func (m *MultipartResponse) close() {
+	for _, part := range m.Parts {
+		if closer, ok := part.Body.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// writeMultipart streams all parts of m flushing after every part,
+// as the status is already sent errors can only be logged
+func (api *DefaultAPI) writeMultipart(rw http.ResponseWriter, request *http.Request, code int, header http.Header, m *MultipartResponse) {
+	defer m.close()
+
+	for name, values := range header {
+		for _, value := range values {
+			rw.Header().Add(name, value)
+		}
+	}
+
+	mw := multipart.NewWriter(rw)
+	rw.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	rw.WriteHeader(code)
+
+	flusher, _ := rw.(http.Flusher)
+
+	for i, part := range m.Parts {
+		if err := request.Context().Err(); err != nil {
+			api.logRequest(request, StatusClientClosedRequest, "client gone before part %d: %s", i, err)
+			return
+		}
+
+		pw, err := mw.CreatePart(part.Header)
+		if err != nil {
+			api.logRequestError(request, code, "err in multipart.CreatePart: %s", err)
+			return
+		}
+		if part.Body != nil {
+			if _, err := io.Copy(pw, part.Body); err != nil {
+				api.logRequestError(request, code, "err writing part %d: %s", i, err)
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		api.logRequestError(request, code, "err in multipart.Close: %s", err)
+	}
+}
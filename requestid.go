@@ -0,0 +1,64 @@
+package sleepy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// DefaultRequestIDHeader is the header used for request IDs
+// unless another one is set with WithRequestIDHeader.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestIDGenerator enables request IDs and uses fn to generate
+// them for requests arriving without one.
+func WithRequestIDGenerator(fn func() string) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.requestIDGenerator = fn
+		if api.requestIDHeader == "" {
+			api.requestIDHeader = DefaultRequestIDHeader
+		}
+	}
+}
+
+// WithRequestIDHeader enables request IDs and reads and writes them
+// using the given header name, e.g. X-Correlation-ID.
+func WithRequestIDHeader(name string) func(*DefaultAPI) {
+	return func(api *DefaultAPI) {
+		api.requestIDHeader = name
+	}
+}
+
+// RequestID returns the ID assigned to the request,
+// or an empty string if request IDs aren't enabled.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID takes the ID from the incoming request or generates
+// a new one, echoes it in the response and stores it in the context
+func (api *DefaultAPI) withRequestID(rw http.ResponseWriter, request *http.Request) *http.Request {
+	id := request.Header.Get(api.requestIDHeader)
+	if id == "" {
+		if api.requestIDGenerator != nil {
+			id = api.requestIDGenerator()
+		} else {
+			id = randomRequestID()
+		}
+	}
+
+	rw.Header().Set(api.requestIDHeader, id)
+	return request.WithContext(context.WithValue(request.Context(), requestIDKey{}, id))
+}
+
+func randomRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,29 @@
+package sleepy
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// VersionInfo describes the running build. Fields are usually filled
+// from variables set with -ldflags "-X main.version=..." at build time.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+type versionResource struct {
+	info VersionInfo
+}
+
+func (v versionResource) Get(r *http.Request, headers http.Header, params httprouter.Params) (int, interface{}, http.Header) {
+	return http.StatusOK, v.info, nil
+}
+
+// AddVersionEndpoint registers a GET resource on path returning
+// the given build information as JSON.
+func (api *DefaultAPI) AddVersionEndpoint(path string, info VersionInfo) {
+	api.AddResource(versionResource{info: info}, path)
+}